@@ -0,0 +1,161 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	elastic "gopkg.in/olivere/elastic.v5"
+
+	"github.com/NyaaPantsu/nyaa/config"
+	"github.com/jinzhu/gorm"
+)
+
+// reindexPageSize is how many torrents BulkReindex loads from the DB and
+// sends to ES per bulk request.
+const reindexPageSize = 500
+
+// esIndexMappingTemplate is an explicit mapping for the torrents index,
+// replacing the dynamic mapping ES would otherwise infer from a dumped
+// TorrentJSON, which tokenizes CJK names poorly and treats hash/category as
+// full text instead of exact-match keywords. The mappings key must match
+// config.DefaultElasticsearchType exactly, or ES applies dynamic mapping to
+// the real type and silently ignores all of this; EnsureIndex fills it in.
+const esIndexMappingTemplate = `{
+	"settings": {
+		"analysis": {
+			"analyzer": {
+				"torrent_name": {
+					"type": "custom",
+					"tokenizer": "icu_tokenizer",
+					"filter": ["icu_folding"]
+				},
+				"file_path": {
+					"type": "custom",
+					"tokenizer": "path_hierarchy_tokenizer"
+				}
+			},
+			"tokenizer": {
+				"path_hierarchy_tokenizer": {
+					"type": "path_hierarchy",
+					"delimiter": "/"
+				}
+			},
+			"normalizer": {
+				"lowercase_normalizer": {
+					"type": "custom",
+					"filter": ["lowercase"]
+				}
+			}
+		}
+	},
+	"mappings": {
+		"%s": {
+			"properties": {
+				"id": {"type": "integer"},
+				"name": {
+					"type": "text",
+					"analyzer": "torrent_name",
+					"fields": {
+						"keyword": {"type": "keyword"}
+					}
+				},
+				"hash": {"type": "keyword", "normalizer": "lowercase_normalizer"},
+				"category": {"type": "keyword"},
+				"sub_category": {"type": "keyword"},
+				"filesize": {"type": "long"},
+				"seeders": {"type": "integer"},
+				"leechers": {"type": "integer"},
+				"downloads": {"type": "integer"},
+				"date": {"type": "date"},
+				"last_scrape": {"type": "date"},
+				"has_torrent_file": {"type": "boolean"},
+				"file_list": {
+					"type": "nested",
+					"properties": {
+						"path": {
+							"type": "text",
+							"analyzer": "file_path",
+							"fields": {
+								"keyword": {"type": "keyword"}
+							}
+						},
+						"filesize": {"type": "long"}
+					}
+				}
+			}
+		}
+	}
+}`
+
+// buildIndexMapping fills esIndexMappingTemplate's %s with
+// config.DefaultElasticsearchType, split out from EnsureIndex so the
+// substitution can be checked against a real JSON parser in tests without
+// needing a live ES client.
+func buildIndexMapping() string {
+	return fmt.Sprintf(esIndexMappingTemplate, config.DefaultElasticsearchType)
+}
+
+// EnsureIndex creates the torrents index with an explicit mapping if it
+// doesn't already exist. Call this once at startup before indexing, so ES
+// doesn't fall back to dynamic field guessing.
+func EnsureIndex(client *elastic.Client) error {
+	ctx := context.Background()
+	exists, err := client.IndexExists(config.DefaultElasticsearchIndex).Do(ctx)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	_, err = client.CreateIndex(config.DefaultElasticsearchIndex).BodyString(buildIndexMapping()).Do(ctx)
+	return err
+}
+
+// fetchReindexPage loads one page of torrents for BulkReindex, its own type
+// so paginate can be driven by a fake in tests without a real database.
+type fetchReindexPage func(offset, limit int) ([]Torrent, error)
+
+// paginate calls fetch for successive reindexPageSize-sized pages (offset
+// 0, reindexPageSize, 2*reindexPageSize, ...), handing each non-empty page
+// to process, and stops at the first page fetch returns empty. Split out
+// from BulkReindex so the paging/stop condition can be tested without a
+// real database or ES client.
+func paginate(fetch fetchReindexPage, process func([]Torrent) error) error {
+	for offset := 0; ; offset += reindexPageSize {
+		torrents, err := fetch(offset, reindexPageSize)
+		if err != nil {
+			return err
+		}
+		if len(torrents) == 0 {
+			return nil
+		}
+		if err := process(torrents); err != nil {
+			return err
+		}
+	}
+}
+
+// BulkReindex pages through every torrent in db and reindexes it into ES
+// using the bulk API, rather than issuing one Index() call per document.
+func BulkReindex(client *elastic.Client, db *gorm.DB) error {
+	ctx := context.Background()
+	return paginate(
+		func(offset, limit int) ([]Torrent, error) {
+			var torrents []Torrent
+			err := db.Preload("FileList").Preload("Uploader").
+				Order("torrent_id").Offset(offset).Limit(limit).
+				Find(&torrents).Error
+			return torrents, err
+		},
+		func(torrents []Torrent) error {
+			bulk := client.Bulk().Index(config.DefaultElasticsearchIndex).Type(config.DefaultElasticsearchType)
+			for _, t := range torrents {
+				torrentJSON := t.ToJSON()
+				bulk.Add(elastic.NewBulkIndexRequest().Id(strconv.FormatUint(uint64(torrentJSON.ID), 10)).Doc(torrentJSON))
+			}
+			_, err := bulk.Do(ctx)
+			return err
+		},
+	)
+}
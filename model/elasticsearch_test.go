@@ -0,0 +1,100 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/NyaaPantsu/nyaa/config"
+)
+
+func TestBuildIndexMapping(t *testing.T) {
+	mapping := buildIndexMapping()
+
+	var parsed map[string]interface{}
+	if err := json.Unmarshal([]byte(mapping), &parsed); err != nil {
+		t.Fatalf("buildIndexMapping produced invalid JSON: %v\n%s", err, mapping)
+	}
+
+	mappings, ok := parsed["mappings"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("mappings key missing or not an object: %v", parsed)
+	}
+	if _, ok := mappings[config.DefaultElasticsearchType]; !ok {
+		t.Errorf("mappings has no key %q (the %%s substitution), got keys %v", config.DefaultElasticsearchType, mappings)
+	}
+}
+
+func TestPaginateStopsOnEmptyPage(t *testing.T) {
+	pages := [][]Torrent{
+		{{ID: 1}, {ID: 2}},
+		{{ID: 3}},
+		{},
+	}
+	var gotOffsets []int
+	fetch := func(offset, limit int) ([]Torrent, error) {
+		gotOffsets = append(gotOffsets, offset)
+		if limit != reindexPageSize {
+			t.Errorf("limit = %d, want reindexPageSize %d", limit, reindexPageSize)
+		}
+		page := pages[len(gotOffsets)-1]
+		return page, nil
+	}
+
+	var processed []Torrent
+	process := func(torrents []Torrent) error {
+		processed = append(processed, torrents...)
+		return nil
+	}
+
+	if err := paginate(fetch, process); err != nil {
+		t.Fatalf("paginate: %v", err)
+	}
+
+	wantOffsets := []int{0, reindexPageSize, 2 * reindexPageSize}
+	if len(gotOffsets) != len(wantOffsets) {
+		t.Fatalf("fetch called with offsets %v, want %v", gotOffsets, wantOffsets)
+	}
+	for i, want := range wantOffsets {
+		if gotOffsets[i] != want {
+			t.Errorf("offset[%d] = %d, want %d", i, gotOffsets[i], want)
+		}
+	}
+	if len(processed) != 3 {
+		t.Errorf("processed %d torrents, want 3 (the empty page should not reach process)", len(processed))
+	}
+}
+
+func TestPaginatePropagatesFetchError(t *testing.T) {
+	wantErr := errors.New("db exploded")
+	fetch := func(offset, limit int) ([]Torrent, error) {
+		return nil, wantErr
+	}
+	process := func(torrents []Torrent) error {
+		t.Fatal("process should not run when fetch fails")
+		return nil
+	}
+
+	if err := paginate(fetch, process); err != wantErr {
+		t.Errorf("paginate error = %v, want %v", err, wantErr)
+	}
+}
+
+func TestPaginatePropagatesProcessError(t *testing.T) {
+	wantErr := errors.New("bulk index failed")
+	calls := 0
+	fetch := func(offset, limit int) ([]Torrent, error) {
+		calls++
+		if calls > 1 {
+			t.Fatal("fetch should not run again after process fails")
+		}
+		return []Torrent{{ID: 1}}, nil
+	}
+	process := func(torrents []Torrent) error {
+		return wantErr
+	}
+
+	if err := paginate(fetch, process); err != wantErr {
+		t.Errorf("paginate error = %v, want %v", err, wantErr)
+	}
+}
@@ -0,0 +1,149 @@
+package metadata
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/anacrolix/dht/v2"
+	"github.com/zeebo/bencode"
+)
+
+// maxTrackerResponseBytes bounds how much of an announce response we'll
+// decode. A real compact peer list response is at most a few KiB; anything
+// past this is a slow or malicious tracker trying to stream unbounded data
+// into memory.
+const maxTrackerResponseBytes = 1 * 1024 * 1024
+
+// discoverPeers collects candidate peer addresses for infoHash, asking the
+// torrent's own trackers first (cheap, already known to be reachable) and
+// then always the DHT as well (slower, but works for trackerless magnets).
+func discoverPeers(ctx context.Context, infoHash [20]byte, trackers []string) ([]string, error) {
+	var peers []string
+	for _, t := range trackers {
+		found, err := scrapeTrackerForPeers(ctx, t, infoHash)
+		if err != nil {
+			continue // a single dead tracker shouldn't sink the whole fetch
+		}
+		peers = append(peers, found...)
+	}
+
+	if dhtPeers, err := queryDHT(ctx, infoHash); err == nil {
+		peers = append(peers, dhtPeers...)
+	}
+
+	return dedupeAddrs(peers), nil
+}
+
+// scrapeTrackerForPeers issues an announce (not a scrape) against an HTTP
+// tracker to get a peer list, since tracker scrapes (UDP or HTTP) don't
+// return peers.
+func scrapeTrackerForPeers(ctx context.Context, tracker string, infoHash [20]byte) ([]string, error) {
+	u, err := url.Parse(tracker)
+	if err != nil || !strings.HasPrefix(u.Scheme, "http") {
+		return nil, err
+	}
+
+	q := u.Query()
+	q.Set("info_hash", string(infoHash[:]))
+	q.Set("peer_id", strings.Repeat("0", 20))
+	q.Set("port", "6881")
+	q.Set("uploaded", "0")
+	q.Set("downloaded", "0")
+	q.Set("left", "1")
+	q.Set("compact", "1")
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var reply struct {
+		Peers string `bencode:"peers"`
+	}
+	body := io.LimitReader(resp.Body, maxTrackerResponseBytes)
+	if err := bencode.NewDecoder(body).Decode(&reply); err != nil {
+		return nil, err
+	}
+	return compactPeersToAddrs(reply.Peers), nil
+}
+
+// compactPeersToAddrs unpacks a BEP-23 compact peer list (4 bytes IP + 2
+// bytes port, big-endian) into dialable "ip:port" strings.
+func compactPeersToAddrs(compact string) []string {
+	raw := []byte(compact)
+	var addrs []string
+	for i := 0; i+6 <= len(raw); i += 6 {
+		ip := net.IP(raw[i : i+4]).String()
+		port := int(raw[i+4])<<8 | int(raw[i+5])
+		addrs = append(addrs, net.JoinHostPort(ip, strconv.Itoa(port)))
+	}
+	return addrs
+}
+
+var (
+	dhtServerOnce sync.Once
+	dhtServer     *dht.Server
+	dhtServerErr  error
+)
+
+// sharedDHTServer lazily starts a single long-lived DHT node and reuses it
+// for every Fetch, since a freshly created node has an empty routing table
+// and needs time to bootstrap before Announce can find anything — one
+// stood up and torn down within a single Fetch call would see ~0 peers.
+func sharedDHTServer() (*dht.Server, error) {
+	dhtServerOnce.Do(func() {
+		dhtServer, dhtServerErr = dht.NewServer(nil)
+	})
+	return dhtServer, dhtServerErr
+}
+
+// queryDHT asks the mainline DHT for peers announcing infoHash, for
+// trackerless or dead-tracker torrents.
+func queryDHT(ctx context.Context, infoHash [20]byte) ([]string, error) {
+	srv, err := sharedDHTServer()
+	if err != nil {
+		return nil, err
+	}
+
+	announce, err := srv.Announce(infoHash, 6881, true)
+	if err != nil {
+		return nil, err
+	}
+	defer announce.Close()
+
+	var addrs []string
+	select {
+	case peers := <-announce.Peers:
+		for _, p := range peers.Peers {
+			addrs = append(addrs, p.String())
+		}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return addrs, nil
+}
+
+func dedupeAddrs(addrs []string) []string {
+	seen := make(map[string]struct{}, len(addrs))
+	out := addrs[:0]
+	for _, a := range addrs {
+		if _, ok := seen[a]; ok {
+			continue
+		}
+		seen[a] = struct{}{}
+		out = append(out, a)
+	}
+	return out
+}
@@ -0,0 +1,120 @@
+// Package metadata implements BEP-9 (ut_metadata) metadata exchange so that
+// magnet-only or trackerless torrents can have their file list and size
+// filled in without the uploader supplying a .torrent file.
+package metadata
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/zeebo/bencode"
+)
+
+// Info is the subset of a parsed info dictionary callers need to persist
+// back onto a model.Torrent.
+type Info struct {
+	Files       []InfoFile
+	Total       int64
+	PieceLength int64
+	Pieces      []byte // concatenated SHA-1 piece hashes, 20 bytes each
+}
+
+// InfoFile is a single file entry from a (possibly multi-file) info
+// dictionary.
+type InfoFile struct {
+	Path   []string
+	Length int64
+}
+
+// swarmTimeout bounds how long Fetch will spend talking to a single swarm
+// before giving up, so a dead torrent can't tie up a worker forever.
+const swarmTimeout = 45 * time.Second
+
+// DefaultPool is the shared BEP-9 fetch pool used by Torrent.FetchMetadata,
+// so concurrent uploads of the same infohash dedupe onto one swarm
+// connection and the number of swarms open at once stays bounded.
+var DefaultPool = NewPool(defaultWorkers, Fetch)
+
+// Fetch discovers peers for hash via DHT and the given trackers, downloads
+// the info dictionary from the first peer willing to serve it over
+// ut_metadata, verifies it against hash and returns the parsed file list.
+func Fetch(ctx context.Context, hash string, trackers []string) (*Info, error) {
+	infoHash, err := decodeHash(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, swarmTimeout)
+	defer cancel()
+
+	peers, err := discoverPeers(ctx, infoHash, trackers)
+	if err != nil {
+		return nil, err
+	}
+	if len(peers) == 0 {
+		return nil, errors.New("metadata: no peers found for swarm")
+	}
+
+	var lastErr error
+	for _, addr := range peers {
+		raw, err := fetchFromPeer(ctx, addr, infoHash)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return parseInfoDict(raw)
+	}
+	return nil, fmt.Errorf("metadata: exhausted %d peers, last error: %v", len(peers), lastErr)
+}
+
+func decodeHash(hash string) ([20]byte, error) {
+	var out [20]byte
+	raw, err := hex.DecodeString(hash)
+	if err != nil {
+		return out, fmt.Errorf("metadata: invalid infohash %q: %w", hash, err)
+	}
+	if len(raw) != 20 {
+		return out, fmt.Errorf("metadata: infohash %q is %d bytes, want 20", hash, len(raw))
+	}
+	copy(out[:], raw)
+	return out, nil
+}
+
+type rawInfoFile struct {
+	Length int64    `bencode:"length"`
+	Path   []string `bencode:"path"`
+}
+
+type rawInfoDict struct {
+	Name        string        `bencode:"name"`
+	Length      int64         `bencode:"length,omitempty"`
+	Files       []rawInfoFile `bencode:"files,omitempty"`
+	PieceLength int64         `bencode:"piece length"`
+	Pieces      string        `bencode:"pieces"`
+}
+
+func parseInfoDict(raw []byte) (*Info, error) {
+	var dict rawInfoDict
+	if err := bencode.DecodeBytes(raw, &dict); err != nil {
+		return nil, fmt.Errorf("metadata: decoding info dict: %w", err)
+	}
+
+	if len(dict.Files) == 0 {
+		return &Info{
+			Files:       []InfoFile{{Path: []string{dict.Name}, Length: dict.Length}},
+			Total:       dict.Length,
+			PieceLength: dict.PieceLength,
+			Pieces:      []byte(dict.Pieces),
+		}, nil
+	}
+
+	info := &Info{Files: make([]InfoFile, len(dict.Files)), PieceLength: dict.PieceLength, Pieces: []byte(dict.Pieces)}
+	for i, f := range dict.Files {
+		info.Files[i] = InfoFile{Path: append([]string{dict.Name}, f.Path...), Length: f.Length}
+		info.Total += f.Length
+	}
+	return info, nil
+}
@@ -0,0 +1,96 @@
+package metadata
+
+import (
+	"testing"
+
+	"github.com/zeebo/bencode"
+)
+
+func TestParseInfoDictSingleFile(t *testing.T) {
+	raw, err := bencode.EncodeBytes(rawInfoDict{
+		Name:        "example.iso",
+		Length:      1234,
+		PieceLength: 16384,
+		Pieces:      "01234567890123456789", // one fake 20-byte SHA-1
+	})
+	if err != nil {
+		t.Fatalf("encoding fixture: %v", err)
+	}
+
+	info, err := parseInfoDict(raw)
+	if err != nil {
+		t.Fatalf("parseInfoDict: %v", err)
+	}
+
+	if info.Total != 1234 {
+		t.Errorf("Total = %d, want 1234", info.Total)
+	}
+	if info.PieceLength != 16384 {
+		t.Errorf("PieceLength = %d, want 16384", info.PieceLength)
+	}
+	if len(info.Files) != 1 {
+		t.Fatalf("len(Files) = %d, want 1", len(info.Files))
+	}
+	if got := info.Files[0].Path; len(got) != 1 || got[0] != "example.iso" {
+		t.Errorf("Files[0].Path = %v, want [example.iso]", got)
+	}
+	if info.Files[0].Length != 1234 {
+		t.Errorf("Files[0].Length = %d, want 1234", info.Files[0].Length)
+	}
+}
+
+func TestParseInfoDictMultiFile(t *testing.T) {
+	raw, err := bencode.EncodeBytes(rawInfoDict{
+		Name:        "release",
+		PieceLength: 16384,
+		Pieces:      "01234567890123456789",
+		Files: []rawInfoFile{
+			{Length: 100, Path: []string{"a.txt"}},
+			{Length: 200, Path: []string{"sub", "b.txt"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("encoding fixture: %v", err)
+	}
+
+	info, err := parseInfoDict(raw)
+	if err != nil {
+		t.Fatalf("parseInfoDict: %v", err)
+	}
+
+	if info.Total != 300 {
+		t.Errorf("Total = %d, want 300", info.Total)
+	}
+	if len(info.Files) != 2 {
+		t.Fatalf("len(Files) = %d, want 2", len(info.Files))
+	}
+
+	want := [][]string{{"release", "a.txt"}, {"release", "sub", "b.txt"}}
+	for i, f := range info.Files {
+		if len(f.Path) != len(want[i]) {
+			t.Fatalf("Files[%d].Path = %v, want %v", i, f.Path, want[i])
+		}
+		for j, part := range f.Path {
+			if part != want[i][j] {
+				t.Errorf("Files[%d].Path = %v, want %v", i, f.Path, want[i])
+			}
+		}
+	}
+}
+
+func TestDecodeHash(t *testing.T) {
+	if _, err := decodeHash("not-hex"); err == nil {
+		t.Error("expected an error for non-hex input")
+	}
+	if _, err := decodeHash("aabb"); err == nil {
+		t.Error("expected an error for a hash shorter than 20 bytes")
+	}
+
+	hash, err := decodeHash("0102030405060708090a0b0c0d0e0f1011121314")
+	if err != nil {
+		t.Fatalf("decodeHash: %v", err)
+	}
+	if hash[0] != 0x01 || hash[19] != 0x14 {
+		t.Errorf("decodeHash produced unexpected bytes: %x", hash)
+	}
+}
@@ -0,0 +1,130 @@
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// defaultWorkers bounds how many swarm connections can be open for BEP-9
+// fetches at once, so a burst of magnet-only uploads can't each open their
+// own swarm and overwhelm the box.
+const defaultWorkers = 8
+
+// FetchFunc resolves a torrent hash/tracker pair into parsed metadata. It
+// exists so callers can swap in Fetch for real use and a stub for tests.
+type FetchFunc func(ctx context.Context, hash string, trackers []string) (*Info, error)
+
+// Job is a single torrent queued for metadata fetching.
+type Job struct {
+	TorrentID uint
+	Hash      string
+	Trackers  []string
+}
+
+type fetchResult struct {
+	info *Info
+	err  error
+}
+
+// pendingFetch tracks an in-flight Fetch for one infohash so that callers
+// asking for the same hash while it's already running wait on that fetch
+// instead of starting a duplicate swarm connection.
+type pendingFetch struct {
+	done chan struct{}
+	fetchResult
+}
+
+// Pool is a bounded worker pool that fetches BEP-9 metadata for queued
+// torrents, deduping concurrent requests for the same infohash.
+type Pool struct {
+	fetch FetchFunc
+	queue chan poolJob
+
+	mu      sync.Mutex
+	pending map[string]*pendingFetch
+}
+
+type poolJob struct {
+	Job
+	resultCh chan<- fetchResult
+}
+
+// NewPool creates a metadata fetch pool with the given number of workers.
+// workers must be >= 1. Call Fetch to enqueue a job and wait for its result.
+func NewPool(workers int, fetch FetchFunc) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	p := &Pool{
+		fetch:   fetch,
+		queue:   make(chan poolJob, workers*4),
+		pending: make(map[string]*pendingFetch),
+	}
+	for i := 0; i < workers; i++ {
+		go p.worker()
+	}
+	return p
+}
+
+// Fetch dedupes and bounds concurrent BEP-9 fetches for job.Hash: if a fetch
+// for the same hash is already running, Fetch waits for it instead of
+// starting a second swarm connection. It blocks until the result (ours or
+// the in-flight one's) is ready or ctx is cancelled; the fetch itself keeps
+// running on the pool's worker even if ctx is cancelled first.
+func (p *Pool) Fetch(ctx context.Context, job Job) (*Info, error) {
+	p.mu.Lock()
+	if pf, ok := p.pending[job.Hash]; ok {
+		p.mu.Unlock()
+		select {
+		case <-pf.done:
+			return pf.info, pf.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	pf := &pendingFetch{done: make(chan struct{})}
+	p.pending[job.Hash] = pf
+	p.mu.Unlock()
+
+	resultCh := make(chan fetchResult, 1)
+	select {
+	case p.queue <- poolJob{Job: job, resultCh: resultCh}:
+	case <-ctx.Done():
+		// The job never reached a worker, so nothing will ever close
+		// pf.done or remove it from p.pending. Do both ourselves, or
+		// every later Fetch for this hash would dedupe onto a dead
+		// entry and just wait out its own context instead of retrying.
+		p.mu.Lock()
+		delete(p.pending, job.Hash)
+		p.mu.Unlock()
+		pf.err = fmt.Errorf("metadata: %s never reached a worker: %w", job.Hash, ctx.Err())
+		close(pf.done)
+		return nil, ctx.Err()
+	}
+
+	select {
+	case res := <-resultCh:
+		return res.info, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (p *Pool) worker() {
+	for job := range p.queue {
+		info, err := p.fetch(context.Background(), job.Hash, job.Trackers)
+		res := fetchResult{info: info, err: err}
+
+		p.mu.Lock()
+		pf := p.pending[job.Hash]
+		delete(p.pending, job.Hash)
+		p.mu.Unlock()
+
+		if pf != nil {
+			pf.fetchResult = res
+			close(pf.done)
+		}
+		job.resultCh <- res
+	}
+}
@@ -0,0 +1,134 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPoolFetchDedupesConcurrentCallers(t *testing.T) {
+	var calls int
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	fetch := func(ctx context.Context, hash string, trackers []string) (*Info, error) {
+		calls++
+		started <- struct{}{}
+		<-release
+		return &Info{Total: 42}, nil
+	}
+
+	p := NewPool(1, fetch)
+	ctx := context.Background()
+
+	results := make(chan *Info, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			info, err := p.Fetch(ctx, Job{Hash: "samehash"})
+			if err != nil {
+				t.Errorf("Fetch: %v", err)
+				return
+			}
+			results <- info
+		}()
+	}
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("worker never started the fetch")
+	}
+	// Give the second caller time to reach the dedup wait before we let the
+	// in-flight fetch finish.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case info := <-results:
+			if info.Total != 42 {
+				t.Errorf("info.Total = %d, want 42", info.Total)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for a deduped caller's result")
+		}
+	}
+	if calls != 1 {
+		t.Errorf("fetch was called %d times, want 1 (calls should dedupe)", calls)
+	}
+}
+
+// TestPoolFetchCleansUpAfterCancelledEnqueue exercises the case where a
+// caller's context is cancelled after its pendingFetch is registered but
+// before the job is accepted onto p.queue. If the pool didn't clean up that
+// pendingFetch, every later Fetch for the same hash would dedupe onto the
+// dead entry and hang until its own context expired, for the life of the
+// process.
+func TestPoolFetchCleansUpAfterCancelledEnqueue(t *testing.T) {
+	gate := make(chan struct{})
+	started := make(chan string, 16)
+	fetch := func(ctx context.Context, hash string, trackers []string) (*Info, error) {
+		started <- hash
+		<-gate
+		return &Info{Total: 7}, nil
+	}
+
+	p := NewPool(1, fetch)
+
+	// Occupy the single worker so nothing ever drains p.queue.
+	go p.Fetch(context.Background(), Job{Hash: "busy"})
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("worker never picked up the first job")
+	}
+
+	// Fill the queue's buffer (capacity workers*4 == 4) directly so the next
+	// enqueue attempt has no room and must block.
+	for i := 0; i < 4; i++ {
+		p.queue <- poolJob{Job: Job{Hash: fmt.Sprintf("filler%d", i)}, resultCh: make(chan fetchResult, 1)}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	fetchErr := make(chan error, 1)
+	go func() {
+		_, err := p.Fetch(ctx, Job{Hash: "target"})
+		fetchErr <- err
+	}()
+
+	// Give Fetch time to register "target" in p.pending before its enqueue
+	// attempt loses the race to ctx cancellation.
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-fetchErr:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Fetch error = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Fetch did not return after its context was cancelled")
+	}
+
+	p.mu.Lock()
+	_, stillPending := p.pending["target"]
+	p.mu.Unlock()
+	if stillPending {
+		t.Fatal("pendingFetch for \"target\" was not cleaned up after the cancelled enqueue")
+	}
+
+	// Unblock every queued fetch so the pool can make progress again, then
+	// confirm a fresh Fetch for the same hash actually retries instead of
+	// dedupeing onto the (now-cleaned-up) dead entry.
+	close(gate)
+	for i := 0; i < 4; i++ {
+		<-started
+	}
+
+	retryCtx, retryCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer retryCancel()
+	if _, err := p.Fetch(retryCtx, Job{Hash: "target"}); err != nil {
+		t.Fatalf("retrying Fetch for the same hash: %v", err)
+	}
+}
@@ -0,0 +1,243 @@
+package metadata
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/zeebo/bencode"
+)
+
+// protocolHeader is the fixed BitTorrent handshake preamble (BEP-3). The
+// extension protocol bit (BEP-10) that tells peers to expect an extended
+// handshake is carried separately, in extendedReservedBits.
+var protocolHeader = []byte("\x13BitTorrent protocol")
+
+// extendedReservedBits marks support for the extension protocol (BEP-10) in
+// the 8 reserved handshake bytes.
+var extendedReservedBits = [8]byte{0, 0, 0, 0, 0, 0x10, 0, 0}
+
+const (
+	extendedMessageID  = 20
+	extHandshakeMsgID  = 0
+	metadataPieceBytes = 16 * 1024
+
+	// maxMetadataSize bounds the metadata_size a peer may advertise before we
+	// allocate piece buffers for it. Real .torrent info dicts are at most a
+	// few MiB even for huge multi-file releases; anything past that is a
+	// malicious or broken peer trying to force an oversized allocation.
+	maxMetadataSize = 16 * 1024 * 1024
+
+	// maxWireMessageLength bounds the length prefix readMessage will honor.
+	// The extended handshake is a small bencoded dict and a ut_metadata piece
+	// message is at most metadataPieceBytes plus a little bencoded overhead,
+	// so anything past this is a peer lying about a message length to force
+	// an oversized allocation before we've even looked at the payload.
+	maxWireMessageLength = metadataPieceBytes + 1024
+)
+
+// ErrHashMismatch is returned when the assembled metadata's SHA-1 does not
+// match the torrent's infohash, which would indicate a malicious or
+// corrupted peer.
+var ErrHashMismatch = errors.New("metadata: assembled info dict does not match infohash")
+
+type extHandshake struct {
+	M            map[string]int `bencode:"m"`
+	MetadataSize int            `bencode:"metadata_size"`
+}
+
+type metadataMessage struct {
+	MsgType int `bencode:"msg_type"`
+	Piece   int `bencode:"piece"`
+	// TotalSize is only set on msg_type 1 (data).
+	TotalSize int `bencode:"total_size,omitempty"`
+}
+
+// fetchFromPeer opens a TCP connection to addr, performs the BEP-3/BEP-10
+// handshakes, negotiates the ut_metadata extension (BEP-9) and downloads
+// every metadata piece. The returned bytes are the bencoded info dictionary,
+// already verified against infoHash.
+func fetchFromPeer(ctx context.Context, addr string, infoHash [20]byte) ([]byte, error) {
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if err := handshake(conn, infoHash); err != nil {
+		return nil, err
+	}
+
+	utMetadataID, metadataSize, err := extendedHandshake(conn)
+	if err != nil {
+		return nil, err
+	}
+	if metadataSize <= 0 {
+		return nil, errors.New("metadata: peer did not advertise metadata_size")
+	}
+	if metadataSize > maxMetadataSize {
+		return nil, fmt.Errorf("metadata: peer advertised metadata_size %d, exceeds %d byte limit", metadataSize, maxMetadataSize)
+	}
+
+	numPieces := (metadataSize + metadataPieceBytes - 1) / metadataPieceBytes
+	pieces := make([][]byte, numPieces)
+
+	for i := 0; i < numPieces; i++ {
+		piece, err := requestPiece(conn, utMetadataID, i)
+		if err != nil {
+			return nil, err
+		}
+		pieces[i] = piece
+	}
+
+	info := bytes.Join(pieces, nil)
+	if len(info) != metadataSize {
+		return nil, fmt.Errorf("metadata: assembled %d bytes, peer advertised %d", len(info), metadataSize)
+	}
+	if sha1.Sum(info) != infoHash {
+		return nil, ErrHashMismatch
+	}
+	return info, nil
+}
+
+func handshake(conn net.Conn, infoHash [20]byte) error {
+	out := make([]byte, 0, 68)
+	out = append(out, protocolHeader...)
+	out = append(out, extendedReservedBits[:]...)
+	out = append(out, infoHash[:]...)
+	out = append(out, bytes.Repeat([]byte{0}, 20)...) // our peer id, anonymous is fine for a metadata-only connection
+	if _, err := conn.Write(out); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 68)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if !bytes.Equal(reply[:20], protocolHeader) {
+		return errors.New("metadata: unexpected handshake preamble")
+	}
+	if reply[25]&0x10 == 0 {
+		return errors.New("metadata: peer does not support the extension protocol")
+	}
+	if !bytes.Equal(reply[28:48], infoHash[:]) {
+		return errors.New("metadata: peer echoed a different infohash")
+	}
+	return nil
+}
+
+func extendedHandshake(conn net.Conn) (utMetadataID, metadataSize int, err error) {
+	payload, err := bencode.EncodeBytes(map[string]interface{}{
+		"m": map[string]int{"ut_metadata": 1},
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := writeMessage(conn, extendedMessageID, append([]byte{extHandshakeMsgID}, payload...)); err != nil {
+		return 0, 0, err
+	}
+
+	for {
+		id, body, err := readMessage(conn)
+		if err != nil {
+			return 0, 0, err
+		}
+		if id != extendedMessageID || len(body) == 0 || body[0] != extHandshakeMsgID {
+			continue // ignore unrelated wire messages (bitfield, have, choke, ...)
+		}
+		var hs extHandshake
+		if err := bencode.DecodeBytes(body[1:], &hs); err != nil {
+			return 0, 0, err
+		}
+		utID, ok := hs.M["ut_metadata"]
+		if !ok {
+			return 0, 0, errors.New("metadata: peer does not support ut_metadata")
+		}
+		return utID, hs.MetadataSize, nil
+	}
+}
+
+func requestPiece(conn net.Conn, utMetadataID, piece int) ([]byte, error) {
+	req, err := bencode.EncodeBytes(metadataMessage{MsgType: 0, Piece: piece})
+	if err != nil {
+		return nil, err
+	}
+	if err := writeMessage(conn, extendedMessageID, append([]byte{byte(utMetadataID)}, req...)); err != nil {
+		return nil, err
+	}
+
+	for {
+		id, body, err := readMessage(conn)
+		if err != nil {
+			return nil, err
+		}
+		if id != extendedMessageID || len(body) == 0 || int(body[0]) != utMetadataID {
+			continue
+		}
+		var msg metadataMessage
+		dec := bencode.NewDecoder(bytes.NewReader(body[1:]))
+		if err := dec.Decode(&msg); err != nil {
+			return nil, err
+		}
+		// Whatever the decoder didn't consume is the raw metadata piece
+		// appended after the dict, per BEP-9. BytesParsed() reflects what
+		// Decode actually consumed; the underlying bufio.Reader typically
+		// reads further ahead than that, so bytes.Reader.Len() would
+		// under-report how much of body is left for the dict.
+		rest := body[1+dec.BytesParsed():]
+		switch msg.MsgType {
+		case 1: // data
+			if msg.Piece != piece {
+				continue
+			}
+			return rest, nil
+		case 2: // reject
+			return nil, fmt.Errorf("metadata: peer rejected piece %d", piece)
+		}
+	}
+}
+
+func writeMessage(conn net.Conn, id byte, payload []byte) error {
+	length := uint32(len(payload) + 1)
+	header := make([]byte, 5)
+	binary.BigEndian.PutUint32(header, length)
+	header[4] = id
+	if _, err := conn.Write(header); err != nil {
+		return err
+	}
+	_, err := conn.Write(payload)
+	return err
+}
+
+// readMessage reads a single length-prefixed peer wire message, skipping
+// keep-alives (zero-length messages).
+func readMessage(conn net.Conn) (id byte, body []byte, err error) {
+	for {
+		var lengthBuf [4]byte
+		if _, err := io.ReadFull(conn, lengthBuf[:]); err != nil {
+			return 0, nil, err
+		}
+		length := binary.BigEndian.Uint32(lengthBuf[:])
+		if length == 0 {
+			continue // keep-alive
+		}
+		if length > maxWireMessageLength {
+			return 0, nil, fmt.Errorf("metadata: peer sent a %d byte message, exceeds %d byte limit", length, maxWireMessageLength)
+		}
+		buf := make([]byte, length)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return 0, nil, err
+		}
+		return buf[0], buf[1:], nil
+	}
+}
@@ -0,0 +1,150 @@
+package metadata
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/zeebo/bencode"
+)
+
+func TestHandshake(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	var infoHash [20]byte
+	infoHash[0] = 0xaa
+
+	go func() {
+		req := make([]byte, 68)
+		io.ReadFull(server, req)
+		reply := make([]byte, 0, 68)
+		reply = append(reply, protocolHeader...)
+		reply = append(reply, extendedReservedBits[:]...)
+		reply = append(reply, infoHash[:]...)
+		reply = append(reply, make([]byte, 20)...)
+		server.Write(reply)
+	}()
+
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := handshake(client, infoHash); err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+}
+
+func TestHandshakeRejectsMismatchedInfoHash(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	var infoHash, otherHash [20]byte
+	infoHash[0] = 0xaa
+	otherHash[0] = 0xbb
+
+	go func() {
+		req := make([]byte, 68)
+		io.ReadFull(server, req)
+		reply := make([]byte, 0, 68)
+		reply = append(reply, protocolHeader...)
+		reply = append(reply, extendedReservedBits[:]...)
+		reply = append(reply, otherHash[:]...)
+		reply = append(reply, make([]byte, 20)...)
+		server.Write(reply)
+	}()
+
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := handshake(client, infoHash); err == nil {
+		t.Error("expected an error for a mismatched infohash")
+	}
+}
+
+func TestExtendedHandshake(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		if _, _, err := readMessage(server); err != nil {
+			t.Errorf("fake peer: reading client's extended handshake: %v", err)
+			return
+		}
+		payload, err := bencode.EncodeBytes(extHandshake{
+			M:            map[string]int{"ut_metadata": 3},
+			MetadataSize: 42,
+		})
+		if err != nil {
+			t.Errorf("fake peer: encoding handshake reply: %v", err)
+			return
+		}
+		if err := writeMessage(server, extendedMessageID, append([]byte{extHandshakeMsgID}, payload...)); err != nil {
+			t.Errorf("fake peer: writing handshake reply: %v", err)
+		}
+	}()
+
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	utID, size, err := extendedHandshake(client)
+	if err != nil {
+		t.Fatalf("extendedHandshake: %v", err)
+	}
+	if utID != 3 {
+		t.Errorf("utMetadataID = %d, want 3", utID)
+	}
+	if size != 42 {
+		t.Errorf("metadataSize = %d, want 42", size)
+	}
+}
+
+func TestRequestPiece(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	const utMetadataID = 5
+	want := []byte("fake bencoded info dict piece")
+
+	go func() {
+		if _, _, err := readMessage(server); err != nil {
+			t.Errorf("fake peer: reading piece request: %v", err)
+			return
+		}
+		dict, err := bencode.EncodeBytes(metadataMessage{MsgType: 1, Piece: 0, TotalSize: len(want)})
+		if err != nil {
+			t.Errorf("fake peer: encoding piece reply: %v", err)
+			return
+		}
+		body := append([]byte{utMetadataID}, dict...)
+		body = append(body, want...)
+		if err := writeMessage(server, extendedMessageID, body); err != nil {
+			t.Errorf("fake peer: writing piece reply: %v", err)
+		}
+	}()
+
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	got, err := requestPiece(client, utMetadataID, 0)
+	if err != nil {
+		t.Fatalf("requestPiece: %v", err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("requestPiece = %q, want %q", got, want)
+	}
+}
+
+func TestReadMessageRejectsOversizedLength(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		var lengthBuf [4]byte
+		binary.BigEndian.PutUint32(lengthBuf[:], 0xFFFFFFF0) // ~4GiB, way past maxWireMessageLength
+		server.Write(lengthBuf[:])
+	}()
+
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := readMessage(client); err == nil {
+		t.Error("expected an error for an oversized length prefix")
+	}
+}
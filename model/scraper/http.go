@@ -0,0 +1,81 @@
+package scraper
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/zeebo/bencode"
+)
+
+// maxScrapeResponseBytes bounds how much of a scrape response we'll decode.
+// A real response is a handful of bytes per hash; anything past this is a
+// slow or malicious tracker trying to stream unbounded data into memory.
+const maxScrapeResponseBytes = 1 * 1024 * 1024
+
+type httpScrapeFile struct {
+	Complete   uint32 `bencode:"complete"`
+	Downloaded uint32 `bencode:"downloaded"`
+	Incomplete uint32 `bencode:"incomplete"`
+}
+
+type httpScrapeResponse struct {
+	Files map[string]httpScrapeFile `bencode:"files"`
+}
+
+// httpScrape performs the old-style HTTP tracker scrape convention (the
+// announce URL's last path segment "announce" replaced with "scrape"),
+// used as a fallback for http:// trackers that don't support BEP-15.
+func httpScrape(ctx context.Context, trackerURL string, hashes [][20]byte) (map[[20]byte]scrapeStats, error) {
+	scrapeURL, err := toScrapeURL(trackerURL)
+	if err != nil {
+		return nil, err
+	}
+
+	q := scrapeURL.Query()
+	for _, h := range hashes {
+		q.Add("info_hash", string(h[:]))
+	}
+	scrapeURL.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, scrapeURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var parsed httpScrapeResponse
+	body := io.LimitReader(resp.Body, maxScrapeResponseBytes)
+	if err := bencode.NewDecoder(body).Decode(&parsed); err != nil {
+		return nil, err
+	}
+
+	out := make(map[[20]byte]scrapeStats, len(parsed.Files))
+	for _, h := range hashes {
+		f, ok := parsed.Files[string(h[:])]
+		if !ok {
+			continue
+		}
+		out[h] = scrapeStats{Seeders: f.Complete, Completed: f.Downloaded, Leechers: f.Incomplete}
+	}
+	return out, nil
+}
+
+// toScrapeURL swaps the trailing "/announce" path segment for "/scrape", per
+// the long-standing (unofficial but near-universal) HTTP scrape convention.
+func toScrapeURL(trackerURL string) (*url.URL, error) {
+	u, err := url.Parse(trackerURL)
+	if err != nil {
+		return nil, err
+	}
+	const suffix = "/announce"
+	if len(u.Path) >= len(suffix) && u.Path[len(u.Path)-len(suffix):] == suffix {
+		u.Path = u.Path[:len(u.Path)-len(suffix)] + "/scrape"
+	}
+	return u, nil
+}
@@ -0,0 +1,287 @@
+// Package scraper replaces the old best-effort LastScrape update with a
+// proper BEP-15 UDP tracker client, falling back to the HTTP scrape
+// convention for http:// trackers.
+package scraper
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/NyaaPantsu/nyaa/model"
+)
+
+var (
+	scrapeSuccess = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "nyaa_tracker_scrape_success_total",
+		Help: "Number of successful tracker scrapes, by tracker.",
+	}, []string{"tracker"})
+	scrapeFailure = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "nyaa_tracker_scrape_failure_total",
+		Help: "Number of failed tracker scrapes, by tracker.",
+	}, []string{"tracker"})
+	scrapeAllFailure = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "nyaa_tracker_scrape_all_failure_total",
+		Help: "Number of periodic ScrapeAll runs that failed to even load torrents.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(scrapeSuccess, scrapeFailure, scrapeAllFailure)
+}
+
+// maxBackoffSteps bounds the exponential backoff at 15*2^8 seconds
+// (~64 minutes) per BEP-15's recommended retry schedule.
+const maxBackoffSteps = 8
+
+// Job is a torrent queued for a scrape against one of its trackers.
+type Job struct {
+	TorrentID uint
+	Hash      string
+}
+
+// Manager runs one goroutine per unique tracker URL, batching queued jobs
+// into BEP-15 scrape requests (or HTTP scrape requests for http://
+// trackers) and writing results back to the torrents table in bulk.
+type Manager struct {
+	db *gorm.DB
+
+	mu      sync.Mutex
+	queue   map[string][]Job      // tracker URL -> pending jobs
+	running map[string]bool       // tracker URL -> worker goroutine alive
+	clients map[string]*udpClient // tracker URL -> cached connection_id, so it survives across scrapes
+}
+
+// NewManager creates a scrape Manager backed by db. Call Enqueue to queue
+// torrents for scraping against a tracker; a goroutine is started lazily
+// the first time a tracker is seen and kept alive as long as jobs keep
+// arriving for it.
+func NewManager(db *gorm.DB) *Manager {
+	return &Manager{
+		db:      db,
+		queue:   make(map[string][]Job),
+		running: make(map[string]bool),
+		clients: make(map[string]*udpClient),
+	}
+}
+
+// ScrapeAll loads every torrent and enqueues a Job against each of its
+// trackers, replacing the old best-effort LastScrape update path wholesale.
+// Call RunPeriodically instead of calling ScrapeAll directly to have it run
+// on a schedule.
+func (m *Manager) ScrapeAll() error {
+	var torrents []model.Torrent
+	if err := m.db.Select("torrent_id, torrent_hash, trackers").Find(&torrents).Error; err != nil {
+		return err
+	}
+	for _, t := range torrents {
+		job := Job{TorrentID: t.ID, Hash: t.Hash}
+		for _, tracker := range t.GetTrackersArray() {
+			m.Enqueue(tracker, job)
+		}
+	}
+	return nil
+}
+
+// RunPeriodically calls ScrapeAll every interval until stop is closed,
+// giving every torrent's LastScrape a chance to get refreshed on a
+// schedule. Unlike AttachMirrors' admin HTTP endpoint (see
+// Torrent.AttachMirrors), which genuinely needs a controllers/router
+// package this tree doesn't have, "run this periodically" only needs a
+// time.Ticker, so it doesn't need a cron/scheduler package to live in:
+// this is the whole scheduler.
+func (m *Manager) RunPeriodically(interval time.Duration, stop <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := m.ScrapeAll(); err != nil {
+					scrapeAllFailure.Inc()
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Enqueue queues job to be scraped against tracker, starting a worker
+// goroutine for that tracker if one isn't already running.
+func (m *Manager) Enqueue(tracker string, job Job) {
+	m.mu.Lock()
+	m.queue[tracker] = append(m.queue[tracker], job)
+	start := !m.running[tracker]
+	if start {
+		m.running[tracker] = true
+	}
+	m.mu.Unlock()
+
+	if start {
+		go m.run(tracker)
+	}
+}
+
+// requeue puts jobs back at the front of tracker's queue, for a batch that
+// failed and needs to be retried on the next attempt.
+func (m *Manager) requeue(tracker string, jobs []Job) {
+	m.mu.Lock()
+	m.queue[tracker] = append(jobs, m.queue[tracker]...)
+	m.mu.Unlock()
+}
+
+func (m *Manager) run(tracker string) {
+	backoff := time.Duration(0)
+	for {
+		m.mu.Lock()
+		jobs := m.queue[tracker]
+		if len(jobs) == 0 {
+			// No work left: mark not-running before releasing the lock so an
+			// Enqueue racing with our exit either lands before this (and we
+			// loop again) or sees running == false and starts a fresh worker.
+			m.running[tracker] = false
+			m.mu.Unlock()
+			return
+		}
+		delete(m.queue, tracker)
+		m.mu.Unlock()
+
+		err := m.scrapeBatch(tracker, jobs)
+		if err != nil {
+			scrapeFailure.WithLabelValues(tracker).Inc()
+			m.requeue(tracker, jobs)
+			n := backoffSteps(backoff)
+			backoff = 15 * time.Second * time.Duration(1<<uint(n))
+			time.Sleep(backoff)
+			continue
+		}
+		scrapeSuccess.WithLabelValues(tracker).Inc()
+		backoff = 0
+	}
+}
+
+func backoffSteps(prev time.Duration) int {
+	n := 0
+	for d := 15 * time.Second; d <= prev; d *= 2 {
+		n++
+		if n >= maxBackoffSteps {
+			return maxBackoffSteps
+		}
+	}
+	return n
+}
+
+// udpClientFor returns the cached udpClient for tracker, creating one the
+// first time it's seen, so its connection_id cache survives across scrapes.
+func (m *Manager) udpClientFor(tracker, host string) *udpClient {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.clients[tracker]
+	if !ok {
+		c = newUDPClient(host)
+		m.clients[tracker] = c
+	}
+	return c
+}
+
+func (m *Manager) scrapeBatch(tracker string, jobs []Job) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	hashes, byHash := decodeJobHashes(jobs)
+	results, err := m.scrapeTracker(ctx, tracker, hashes)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for hash, stats := range results {
+		// Match on the job's primary key rather than its hash string: hex
+		// decoding/re-encoding would normalize casing, and torrent_hash in
+		// the DB isn't guaranteed to be lowercase.
+		job, ok := byHash[hash]
+		if !ok {
+			continue
+		}
+		m.db.Model(&model.Torrent{}).Where("torrent_id = ?", job.TorrentID).Updates(map[string]interface{}{
+			"seeders":     stats.Seeders,
+			"leechers":    stats.Leechers,
+			"completed":   stats.Completed,
+			"last_scrape": now,
+		})
+	}
+	return nil
+}
+
+// decodeJobHashes decodes each job's hex Hash into raw bytes, skipping any
+// that don't decode to a 20-byte infohash, and keeps a reverse lookup back
+// to the originating Job so callers can match rows on their primary key
+// rather than re-deriving (and possibly mis-casing) the hash string.
+func decodeJobHashes(jobs []Job) ([][20]byte, map[[20]byte]Job) {
+	hashes := make([][20]byte, 0, len(jobs))
+	byHash := make(map[[20]byte]Job, len(jobs))
+	for _, j := range jobs {
+		raw, err := hex.DecodeString(j.Hash)
+		if err != nil || len(raw) != 20 {
+			continue
+		}
+		var h [20]byte
+		copy(h[:], raw)
+		hashes = append(hashes, h)
+		byHash[h] = j
+	}
+	return hashes, byHash
+}
+
+// scrapeTracker dispatches to the UDP or HTTP scrape implementation
+// depending on the tracker's URL scheme, batching at most
+// maxHashesPerScrape hashes per UDP packet.
+func (m *Manager) scrapeTracker(ctx context.Context, tracker string, hashes [][20]byte) (map[[20]byte]scrapeStats, error) {
+	u, err := url.Parse(tracker)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(u.Scheme, "http") {
+		return httpScrape(ctx, tracker, hashes)
+	}
+
+	conn, err := net.Dial("udp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client := m.udpClientFor(tracker, u.Host)
+	out := make(map[[20]byte]scrapeStats, len(hashes))
+	for start := 0; start < len(hashes); start += maxHashesPerScrape {
+		end := start + maxHashesPerScrape
+		if end > len(hashes) {
+			end = len(hashes)
+		}
+		batch := hashes[start:end]
+		stats, err := client.Scrape(conn, batch)
+		if err != nil {
+			return nil, err
+		}
+		if len(stats) != len(batch) {
+			return nil, fmt.Errorf("scraper: tracker returned %d stats for a batch of %d hashes", len(stats), len(batch))
+		}
+		for i, h := range batch {
+			out[h] = stats[i]
+		}
+	}
+	return out, nil
+}
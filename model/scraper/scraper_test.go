@@ -0,0 +1,27 @@
+package scraper
+
+import "testing"
+
+func TestDecodeJobHashes(t *testing.T) {
+	jobs := []Job{
+		{TorrentID: 1, Hash: "0123456789ABCDEF0123456789abcdef01234567"},
+		{TorrentID: 2, Hash: "not-a-valid-hash"},
+		{TorrentID: 3, Hash: "abcd"},
+	}
+
+	hashes, byHash := decodeJobHashes(jobs)
+
+	if len(hashes) != 1 {
+		t.Fatalf("len(hashes) = %d, want 1 (only jobs[0] decodes to 20 bytes)", len(hashes))
+	}
+	job, ok := byHash[hashes[0]]
+	if !ok {
+		t.Fatalf("byHash missing entry for %x", hashes[0])
+	}
+	if job.TorrentID != 1 {
+		t.Errorf("byHash[%x].TorrentID = %d, want 1", hashes[0], job.TorrentID)
+	}
+	if job.Hash != jobs[0].Hash {
+		t.Errorf("byHash[%x].Hash = %q, want %q (original casing preserved)", hashes[0], job.Hash, jobs[0].Hash)
+	}
+}
@@ -0,0 +1,151 @@
+package scraper
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// udpProtocolMagic is the fixed connection_id used to request a real one,
+// per BEP-15.
+const udpProtocolMagic = 0x41727101980
+
+const (
+	actionConnect = 0
+	actionScrape  = 2
+)
+
+// maxHashesPerScrape is the BEP-15 scrape limit most trackers enforce.
+const maxHashesPerScrape = 74
+
+// connIDTTL is how long a connection_id may be reused before reconnecting,
+// per BEP-15 (trackers accept it for 2 minutes).
+const connIDTTL = 2 * time.Minute
+
+type connEntry struct {
+	id        uint64
+	expiresAt time.Time
+}
+
+// udpClient talks BEP-15 to a single UDP tracker, caching its connection_id
+// and batching scrape requests.
+type udpClient struct {
+	addr string
+
+	mu   sync.Mutex
+	conn *connEntry
+}
+
+func newUDPClient(addr string) *udpClient {
+	return &udpClient{addr: addr}
+}
+
+// Scrape returns, for each hash, {seeders, completed, leechers} in the same
+// order as hashes. Callers must pre-chunk hashes to maxHashesPerScrape.
+func (c *udpClient) Scrape(conn net.Conn, hashes [][20]byte) ([]scrapeStats, error) {
+	if len(hashes) == 0 {
+		return nil, nil
+	}
+	if len(hashes) > maxHashesPerScrape {
+		return nil, fmt.Errorf("scraper: %d hashes exceeds the %d-per-packet limit", len(hashes), maxHashesPerScrape)
+	}
+
+	connID, err := c.connectionID(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	txID := rand.Uint32()
+	req := make([]byte, 16+20*len(hashes))
+	binary.BigEndian.PutUint64(req[0:8], connID)
+	binary.BigEndian.PutUint32(req[8:12], actionScrape)
+	binary.BigEndian.PutUint32(req[12:16], txID)
+	for i, h := range hashes {
+		copy(req[16+i*20:16+(i+1)*20], h[:])
+	}
+
+	if _, err := conn.Write(req); err != nil {
+		return nil, err
+	}
+
+	resp := make([]byte, 8+12*len(hashes))
+	n, err := conn.Read(resp)
+	if err != nil {
+		return nil, err
+	}
+	resp = resp[:n]
+	if len(resp) < 8 {
+		return nil, errors.New("scraper: short scrape response")
+	}
+	if binary.BigEndian.Uint32(resp[0:4]) != actionScrape {
+		return nil, errors.New("scraper: unexpected action in scrape response")
+	}
+	if binary.BigEndian.Uint32(resp[4:8]) != txID {
+		return nil, errors.New("scraper: transaction_id mismatch")
+	}
+
+	entries := (len(resp) - 8) / 12
+	stats := make([]scrapeStats, 0, entries)
+	for i := 0; i < entries; i++ {
+		off := 8 + i*12
+		stats = append(stats, scrapeStats{
+			Seeders:   binary.BigEndian.Uint32(resp[off : off+4]),
+			Completed: binary.BigEndian.Uint32(resp[off+4 : off+8]),
+			Leechers:  binary.BigEndian.Uint32(resp[off+8 : off+12]),
+		})
+	}
+	return stats, nil
+}
+
+// connectionID returns a cached connection_id if still within its 2-minute
+// TTL, otherwise performs the BEP-15 connect handshake and caches the
+// result.
+func (c *udpClient) connectionID(conn net.Conn) (uint64, error) {
+	c.mu.Lock()
+	if c.conn != nil && time.Now().Before(c.conn.expiresAt) {
+		id := c.conn.id
+		c.mu.Unlock()
+		return id, nil
+	}
+	c.mu.Unlock()
+
+	txID := rand.Uint32()
+	req := make([]byte, 16)
+	binary.BigEndian.PutUint64(req[0:8], udpProtocolMagic)
+	binary.BigEndian.PutUint32(req[8:12], actionConnect)
+	binary.BigEndian.PutUint32(req[12:16], txID)
+	if _, err := conn.Write(req); err != nil {
+		return 0, err
+	}
+
+	resp := make([]byte, 16)
+	n, err := conn.Read(resp)
+	if err != nil {
+		return 0, err
+	}
+	if n < 16 {
+		return 0, errors.New("scraper: short connect response")
+	}
+	if binary.BigEndian.Uint32(resp[0:4]) != actionConnect {
+		return 0, errors.New("scraper: unexpected action in connect response")
+	}
+	if binary.BigEndian.Uint32(resp[4:8]) != txID {
+		return 0, errors.New("scraper: transaction_id mismatch")
+	}
+	connID := binary.BigEndian.Uint64(resp[8:16])
+
+	c.mu.Lock()
+	c.conn = &connEntry{id: connID, expiresAt: time.Now().Add(connIDTTL)}
+	c.mu.Unlock()
+	return connID, nil
+}
+
+type scrapeStats struct {
+	Seeders   uint32
+	Completed uint32
+	Leechers  uint32
+}
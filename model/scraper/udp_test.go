@@ -0,0 +1,105 @@
+package scraper
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeTracker emulates just enough of a BEP-15 UDP tracker to exercise
+// udpClient.connectionID and udpClient.Scrape: it replies to a connect
+// request with a fixed connection_id, then replies to a scrape request with
+// the given stats, one entry per hash in the request.
+func fakeTracker(t *testing.T, conn net.Conn, connID uint64, stats []scrapeStats) {
+	t.Helper()
+
+	connReq := make([]byte, 16)
+	if _, err := conn.Read(connReq); err != nil {
+		t.Errorf("fakeTracker: reading connect request: %v", err)
+		return
+	}
+	txID := binary.BigEndian.Uint32(connReq[12:16])
+
+	connResp := make([]byte, 16)
+	binary.BigEndian.PutUint32(connResp[0:4], actionConnect)
+	binary.BigEndian.PutUint32(connResp[4:8], txID)
+	binary.BigEndian.PutUint64(connResp[8:16], connID)
+	if _, err := conn.Write(connResp); err != nil {
+		t.Errorf("fakeTracker: writing connect response: %v", err)
+		return
+	}
+
+	scrapeReq := make([]byte, 16+20*len(stats))
+	if _, err := conn.Read(scrapeReq); err != nil {
+		t.Errorf("fakeTracker: reading scrape request: %v", err)
+		return
+	}
+	scrapeTxID := binary.BigEndian.Uint32(scrapeReq[12:16])
+
+	scrapeResp := make([]byte, 8+12*len(stats))
+	binary.BigEndian.PutUint32(scrapeResp[0:4], actionScrape)
+	binary.BigEndian.PutUint32(scrapeResp[4:8], scrapeTxID)
+	for i, s := range stats {
+		off := 8 + i*12
+		binary.BigEndian.PutUint32(scrapeResp[off:off+4], s.Seeders)
+		binary.BigEndian.PutUint32(scrapeResp[off+4:off+8], s.Completed)
+		binary.BigEndian.PutUint32(scrapeResp[off+8:off+12], s.Leechers)
+	}
+	if _, err := conn.Write(scrapeResp); err != nil {
+		t.Errorf("fakeTracker: writing scrape response: %v", err)
+	}
+}
+
+func TestUDPClientScrape(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	want := []scrapeStats{
+		{Seeders: 5, Completed: 100, Leechers: 2},
+		{Seeders: 0, Completed: 3, Leechers: 1},
+	}
+	go fakeTracker(t, server, 0xdeadbeef, want)
+
+	c := newUDPClient("tracker.example:80")
+	hashes := make([][20]byte, len(want))
+	for i := range hashes {
+		hashes[i][0] = byte(i + 1)
+	}
+
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	got, err := c.Scrape(client, hashes)
+	if err != nil {
+		t.Fatalf("Scrape: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("len(got) = %d, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if c.conn == nil || c.conn.id != 0xdeadbeef {
+		t.Errorf("connection_id was not cached after Scrape")
+	}
+}
+
+func TestBackoffSteps(t *testing.T) {
+	cases := []struct {
+		prev time.Duration
+		want int
+	}{
+		{0, 0},
+		{15 * time.Second, 1},
+		{30 * time.Second, 2},
+		{15 * time.Second * (1 << 8), maxBackoffSteps},
+		{time.Hour, maxBackoffSteps},
+	}
+	for _, c := range cases {
+		if got := backoffSteps(c.prev); got != c.want {
+			t.Errorf("backoffSteps(%v) = %d, want %d", c.prev, got, c.want)
+		}
+	}
+}
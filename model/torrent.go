@@ -10,11 +10,13 @@ import (
 	"strings"
 	"time"
 
+	"github.com/zeebo/bencode"
 	elastic "gopkg.in/olivere/elastic.v5"
 
 	"net/url"
 
 	"github.com/NyaaPantsu/nyaa/config"
+	"github.com/NyaaPantsu/nyaa/model/metadata"
 	"github.com/NyaaPantsu/nyaa/util"
 	"github.com/bradfitz/slice"
 )
@@ -58,6 +60,10 @@ type Torrent struct {
 	Description string    `gorm:"column:description"`
 	WebsiteLink string    `gorm:"column:website_link"`
 	Trackers    string    `gorm:"column:trackers"`
+	UrlList     string    `gorm:"column:url_list"`
+	HttpSeeds   string    `gorm:"column:http_seeds"`
+	PieceLength int64     `gorm:"column:piece_length"`
+	Pieces      string    `gorm:"column:pieces"`
 	DeletedAt   *time.Time
 
 	Uploader    *User        `gorm:"AssociationForeignKey:UploaderID;ForeignKey:user_id"`
@@ -177,6 +183,195 @@ func (t *Torrent) GetTrackersArray() (trackers []string) {
 	return
 }
 
+// ParseUrlList : Takes an array of BEP-19 web seed URLs and stores them as a url-encoded string
+func (t *Torrent) ParseUrlList(urlList []string) {
+	v := url.Values{}
+	v["ws"] = urlList
+	t.UrlList = v.Encode()
+}
+
+// GetUrlListArray : Convert the stored web seed url list to an Array
+func (t *Torrent) GetUrlListArray() (urlList []string) {
+	v, _ := url.ParseQuery(t.UrlList)
+	urlList = v["ws"]
+	return
+}
+
+// ParseHttpSeeds : Takes an array of BEP-17 HTTP seed URLs and stores them as a url-encoded string
+func (t *Torrent) ParseHttpSeeds(httpSeeds []string) {
+	v := url.Values{}
+	v["hs"] = httpSeeds
+	t.HttpSeeds = v.Encode()
+}
+
+// GetHttpSeedsArray : Convert the stored HTTP seed list to an Array
+func (t *Torrent) GetHttpSeedsArray() (httpSeeds []string) {
+	v, _ := url.ParseQuery(t.HttpSeeds)
+	httpSeeds = v["hs"]
+	return
+}
+
+// AttachMirrors : Attach BEP-19/BEP-17 web seed and HTTP seed mirror urls to
+// a torrent that already exists, so clients can fall back to HTTP when the
+// swarm is dead. It only sets fields on t, it does not persist them, so
+// callers must db.Save(t) (or .Updates(...)) afterwards, same as
+// FetchMetadata.
+//
+// This tree has no controllers/router package alongside model/ and util/,
+// so there's nowhere to hang the actual admin HTTP endpoint off of; wiring
+// up that route is left to whichever handler package lands it. Unlike
+// ParseUrlList/ParseHttpSeeds, which trust input that's already passed
+// through a form validator on the upload path, AttachMirrors validates the
+// urls itself: an admin endpoint is its only caller, and nothing upstream
+// of it would otherwise catch a typo'd mirror url before it's served back
+// to clients in TorrentJSON/the magnet link.
+func (t *Torrent) AttachMirrors(urlList []string, httpSeeds []string) error {
+	for _, u := range append(append([]string{}, urlList...), httpSeeds...) {
+		parsed, err := url.Parse(u)
+		if err != nil || !strings.HasPrefix(parsed.Scheme, "http") {
+			return fmt.Errorf("model: invalid mirror url %q", u)
+		}
+	}
+	t.ParseUrlList(urlList)
+	t.ParseHttpSeeds(httpSeeds)
+	return nil
+}
+
+// NeedsMetadata : Return whether this torrent was added without a file list
+// or size, e.g. from a bare magnet link, and needs BEP-9 metadata fetching
+func (t *Torrent) NeedsMetadata() bool {
+	return len(t.FileList) == 0 || t.Filesize == 0
+}
+
+// FetchMetadata : Fetch the torrent's file list and size from the swarm via
+// BEP-9 (ut_metadata), seeded from the torrent's own trackers plus the DHT.
+// It goes through metadata.DefaultPool so concurrent fetches for the same
+// infohash dedupe onto one swarm connection. It populates FileList and
+// Filesize on t but does not persist them; callers are responsible for
+// saving t afterwards.
+func (t *Torrent) FetchMetadata(ctx context.Context) error {
+	info, err := metadata.DefaultPool.Fetch(ctx, metadata.Job{
+		TorrentID: t.ID,
+		Hash:      strings.TrimSpace(t.Hash),
+		Trackers:  t.GetTrackersArray(),
+	})
+	if err != nil {
+		return err
+	}
+
+	fileList := make([]File, len(info.Files))
+	for i, f := range info.Files {
+		fileList[i] = File{TorrentID: t.ID, Filesize: f.Length}
+		if err := fileList[i].SetPath(f.Path); err != nil {
+			return fmt.Errorf("metadata: encoding file path: %w", err)
+		}
+	}
+	t.FileList = fileList
+	t.Filesize = info.Total
+	t.PieceLength = info.PieceLength
+	t.Pieces = string(info.Pieces)
+	return nil
+}
+
+// minPieceLength and maxPieceLength bound the piece length we infer for
+// torrents uploaded without one, keeping the piece count in the low
+// thousands regardless of file size.
+const (
+	minPieceLength = 16 * 1024        // 16 KiB
+	maxPieceLength = 16 * 1024 * 1024 // 16 MiB
+)
+
+type torrentFileEntry struct {
+	Length int64    `bencode:"length"`
+	Path   []string `bencode:"path"`
+}
+
+type torrentFileInfo struct {
+	Name        string             `bencode:"name"`
+	PieceLength int64              `bencode:"piece length"`
+	Pieces      string             `bencode:"pieces,omitempty"`
+	Length      int64              `bencode:"length,omitempty"`
+	Files       []torrentFileEntry `bencode:"files,omitempty"`
+}
+
+type torrentFileDict struct {
+	Announce     string          `bencode:"announce"`
+	AnnounceList [][]string      `bencode:"announce-list,omitempty"`
+	CreationDate int64           `bencode:"creation date"`
+	Info         torrentFileInfo `bencode:"info"`
+	UrlList      []string        `bencode:"url-list,omitempty"`
+	HttpSeeds    []string        `bencode:"httpseeds,omitempty"`
+}
+
+// ToTorrentFile : Generate a spec-compliant bencoded .torrent file for this
+// torrent, so it can be served directly instead of only relying on
+// TorrentCacheLink/TorrentStorageLink pointing at an external host. If no
+// piece hashes have been fetched yet (see FetchMetadata), the info dict
+// omits "pieces" and is only a magnet-style stub: enough for a client to
+// identify the torrent by infohash, but not to verify or download data.
+func (t *Torrent) ToTorrentFile() ([]byte, error) {
+	trackers := t.GetTrackersArray()
+	if len(trackers) == 0 {
+		trackers = config.Trackers
+	}
+
+	var announce string
+	var announceList [][]string
+	if len(trackers) > 0 {
+		announce = trackers[0]
+		announceList = make([][]string, len(trackers))
+		for i, tr := range trackers {
+			announceList[i] = []string{tr}
+		}
+	}
+
+	info := torrentFileInfo{
+		Name:        t.Name,
+		PieceLength: t.PieceLength,
+		Pieces:      t.Pieces,
+	}
+	if info.PieceLength == 0 {
+		info.PieceLength = inferPieceLength(t.Filesize)
+	}
+
+	if len(t.FileList) > 1 {
+		info.Files = make([]torrentFileEntry, len(t.FileList))
+		for i, f := range t.FileList {
+			info.Files[i] = torrentFileEntry{Length: f.Filesize, Path: f.Path()}
+		}
+	} else {
+		info.Length = t.Filesize
+	}
+
+	dict := torrentFileDict{
+		Announce:     announce,
+		AnnounceList: announceList,
+		CreationDate: t.Date.Unix(),
+		Info:         info,
+		UrlList:      t.GetUrlListArray(),
+		HttpSeeds:    t.GetHttpSeedsArray(),
+	}
+
+	return bencode.EncodeBytes(dict)
+}
+
+// inferPieceLength picks a piece length for a torrent whose metadata wasn't
+// fetched with one, aiming to keep the piece count in the low thousands
+// regardless of file size.
+func inferPieceLength(size int64) int64 {
+	length := int64(minPieceLength)
+	for length < maxPieceLength && size/length > 2000 {
+		length *= 2
+	}
+	return length
+}
+
+// HasTorrentFile : Return whether ToTorrentFile can produce a fully
+// verifiable .torrent file, i.e. piece hashes have been fetched
+func (t *Torrent) HasTorrentFile() bool {
+	return t.Pieces != ""
+}
+
 /* We need a JSON object instead of a Gorm structure because magnet URLs are
    not in the database and have to be generated dynamically */
 
@@ -204,28 +399,31 @@ type FileJSON struct {
 
 // TorrentJSON for torrent model in json for api
 type TorrentJSON struct {
-	ID           uint          `json:"id"`
-	Name         string        `json:"name"`
-	Status       int           `json:"status"`
-	Hash         string        `json:"hash"`
-	Date         string        `json:"date"`
-	Filesize     int64         `json:"filesize"`
-	Description  template.HTML `json:"description"`
-	Comments     []CommentJSON `json:"comments"`
-	SubCategory  string        `json:"sub_category"`
-	Category     string        `json:"category"`
-	Downloads    int           `json:"downloads"`
-	UploaderID   uint          `json:"uploader_id"`
-	UploaderName template.HTML `json:"uploader_name"`
-	OldUploader  template.HTML `json:"uploader_old"`
-	WebsiteLink  template.URL  `json:"website_link"`
-	Magnet       template.URL  `json:"magnet"`
-	TorrentLink  template.URL  `json:"torrent"`
-	Seeders      uint32        `json:"seeders"`
-	Leechers     uint32        `json:"leechers"`
-	Completed    uint32        `json:"completed"`
-	LastScrape   time.Time     `json:"last_scrape"`
-	FileList     []FileJSON    `json:"file_list"`
+	ID             uint          `json:"id"`
+	Name           string        `json:"name"`
+	Status         int           `json:"status"`
+	Hash           string        `json:"hash"`
+	Date           string        `json:"date"`
+	Filesize       int64         `json:"filesize"`
+	Description    template.HTML `json:"description"`
+	Comments       []CommentJSON `json:"comments"`
+	SubCategory    string        `json:"sub_category"`
+	Category       string        `json:"category"`
+	Downloads      int           `json:"downloads"`
+	UploaderID     uint          `json:"uploader_id"`
+	UploaderName   template.HTML `json:"uploader_name"`
+	OldUploader    template.HTML `json:"uploader_old"`
+	WebsiteLink    template.URL  `json:"website_link"`
+	Magnet         template.URL  `json:"magnet"`
+	TorrentLink    template.URL  `json:"torrent"`
+	Seeders        uint32        `json:"seeders"`
+	Leechers       uint32        `json:"leechers"`
+	Completed      uint32        `json:"completed"`
+	LastScrape     time.Time     `json:"last_scrape"`
+	FileList       []FileJSON    `json:"file_list"`
+	UrlList        []string      `json:"url_list"`
+	HttpSeeds      []string      `json:"http_seeds"`
+	HasTorrentFile bool          `json:"has_torrent_file"`
 }
 
 // ToJSON converts a model.Torrent to its equivalent JSON structure
@@ -236,7 +434,7 @@ func (t *Torrent) ToJSON() TorrentJSON {
 	} else {
 		trackers = t.GetTrackersArray()
 	}
-	magnet := util.InfoHashToMagnet(strings.TrimSpace(t.Hash), t.Name, trackers...)
+	magnet := util.InfoHashToMagnet(strings.TrimSpace(t.Hash), t.Name, t.GetUrlListArray(), trackers...)
 	commentsJSON := make([]CommentJSON, 0, len(t.OldComments)+len(t.Comments))
 	for _, c := range t.OldComments {
 		commentsJSON = append(commentsJSON, CommentJSON{Username: c.Username, UserID: -1, Content: template.HTML(c.Content), Date: c.Date.UTC()})
@@ -287,28 +485,31 @@ func (t *Torrent) ToJSON() TorrentJSON {
 		torrentlink = fmt.Sprintf(config.TorrentStorageLink, t.Hash)
 	}
 	res := TorrentJSON{
-		ID:           t.ID,
-		Name:         t.Name,
-		Status:       t.Status,
-		Hash:         t.Hash,
-		Date:         t.Date.Format(time.RFC3339),
-		Filesize:     t.Filesize,
-		Description:  util.MarkdownToHTML(t.Description),
-		Comments:     commentsJSON,
-		SubCategory:  strconv.Itoa(t.SubCategory),
-		Category:     strconv.Itoa(t.Category),
-		Downloads:    t.Downloads,
-		UploaderID:   uploaderID,
-		UploaderName: util.SafeText(uploader),
-		OldUploader:  util.SafeText(t.OldUploader),
-		WebsiteLink:  util.Safe(t.WebsiteLink),
-		Magnet:       template.URL(magnet),
-		TorrentLink:  util.Safe(torrentlink),
-		Leechers:     t.Leechers,
-		Seeders:      t.Seeders,
-		Completed:    t.Completed,
-		LastScrape:   t.LastScrape,
-		FileList:     fileListJSON,
+		ID:             t.ID,
+		Name:           t.Name,
+		Status:         t.Status,
+		Hash:           t.Hash,
+		Date:           t.Date.Format(time.RFC3339),
+		Filesize:       t.Filesize,
+		Description:    util.MarkdownToHTML(t.Description),
+		Comments:       commentsJSON,
+		SubCategory:    strconv.Itoa(t.SubCategory),
+		Category:       strconv.Itoa(t.Category),
+		Downloads:      t.Downloads,
+		UploaderID:     uploaderID,
+		UploaderName:   util.SafeText(uploader),
+		OldUploader:    util.SafeText(t.OldUploader),
+		WebsiteLink:    util.Safe(t.WebsiteLink),
+		Magnet:         template.URL(magnet),
+		TorrentLink:    util.Safe(torrentlink),
+		Leechers:       t.Leechers,
+		Seeders:        t.Seeders,
+		Completed:      t.Completed,
+		LastScrape:     t.LastScrape,
+		FileList:       fileListJSON,
+		UrlList:        t.GetUrlListArray(),
+		HttpSeeds:      t.GetHttpSeedsArray(),
+		HasTorrentFile: t.HasTorrentFile(),
 	}
 
 	return res
@@ -0,0 +1,144 @@
+package model
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/zeebo/bencode"
+)
+
+func TestToTorrentFileStub(t *testing.T) {
+	tr := &Torrent{Name: "stub.iso", Filesize: 5 * 1024 * 1024}
+	tr.ParseTrackers([]string{"udp://tracker.example:80/announce"})
+
+	data, err := tr.ToTorrentFile()
+	if err != nil {
+		t.Fatalf("ToTorrentFile: %v", err)
+	}
+
+	if bytes.Contains(data, []byte("pieces")) {
+		t.Errorf("stub .torrent should omit the pieces key, got %q", data)
+	}
+
+	var dict torrentFileDict
+	if err := bencode.DecodeBytes(data, &dict); err != nil {
+		t.Fatalf("decoding generated .torrent: %v", err)
+	}
+	if dict.Info.Name != "stub.iso" {
+		t.Errorf("Info.Name = %q, want stub.iso", dict.Info.Name)
+	}
+	if dict.Info.Length != tr.Filesize {
+		t.Errorf("Info.Length = %d, want %d", dict.Info.Length, tr.Filesize)
+	}
+	if dict.Info.PieceLength == 0 {
+		t.Error("Info.PieceLength should be inferred, got 0")
+	}
+	if dict.Announce != "udp://tracker.example:80/announce" {
+		t.Errorf("Announce = %q, want the single configured tracker", dict.Announce)
+	}
+}
+
+func TestToTorrentFileWithPieces(t *testing.T) {
+	a := File{TorrentID: 1, Filesize: 100}
+	if err := a.SetPath([]string{"a.txt"}); err != nil {
+		t.Fatalf("SetPath: %v", err)
+	}
+	b := File{TorrentID: 1, Filesize: 200}
+	if err := b.SetPath([]string{"sub", "b.txt"}); err != nil {
+		t.Fatalf("SetPath: %v", err)
+	}
+
+	tr := &Torrent{
+		Name:        "release",
+		PieceLength: 16384,
+		Pieces:      "01234567890123456789", // one fake 20-byte SHA-1
+		FileList:    []File{a, b},
+	}
+	tr.Filesize = 300
+	tr.ParseTrackers([]string{"udp://a.example:80/announce", "udp://b.example:80/announce"})
+	tr.ParseUrlList([]string{"https://mirror.example/release/"})
+
+	data, err := tr.ToTorrentFile()
+	if err != nil {
+		t.Fatalf("ToTorrentFile: %v", err)
+	}
+
+	var dict torrentFileDict
+	if err := bencode.DecodeBytes(data, &dict); err != nil {
+		t.Fatalf("decoding generated .torrent: %v", err)
+	}
+
+	if dict.Info.Pieces != tr.Pieces {
+		t.Errorf("Info.Pieces = %q, want %q", dict.Info.Pieces, tr.Pieces)
+	}
+	if len(dict.Info.Files) != 2 {
+		t.Fatalf("len(Info.Files) = %d, want 2", len(dict.Info.Files))
+	}
+	if len(dict.AnnounceList) != 2 {
+		t.Errorf("len(AnnounceList) = %d, want 2 tiers", len(dict.AnnounceList))
+	}
+	if len(dict.UrlList) != 1 || dict.UrlList[0] != "https://mirror.example/release/" {
+		t.Errorf("UrlList = %v, want the attached mirror", dict.UrlList)
+	}
+}
+
+func TestUrlListRoundTrip(t *testing.T) {
+	want := []string{"https://mirror1.example/release/", "https://mirror2.example/release/"}
+
+	tr := &Torrent{}
+	tr.ParseUrlList(want)
+
+	got := tr.GetUrlListArray()
+	if len(got) != len(want) {
+		t.Fatalf("GetUrlListArray() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("GetUrlListArray()[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestHttpSeedsRoundTrip(t *testing.T) {
+	want := []string{"https://seed1.example/release/", "https://seed2.example/release/"}
+
+	tr := &Torrent{}
+	tr.ParseHttpSeeds(want)
+
+	got := tr.GetHttpSeedsArray()
+	if len(got) != len(want) {
+		t.Fatalf("GetHttpSeedsArray() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("GetHttpSeedsArray()[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestAttachMirrors(t *testing.T) {
+	tr := &Torrent{}
+	urlList := []string{"https://mirror.example/release/"}
+	httpSeeds := []string{"https://seed.example/release/"}
+
+	if err := tr.AttachMirrors(urlList, httpSeeds); err != nil {
+		t.Fatalf("AttachMirrors: %v", err)
+	}
+
+	if got := tr.GetUrlListArray(); len(got) != 1 || got[0] != urlList[0] {
+		t.Errorf("GetUrlListArray() = %v, want %v", got, urlList)
+	}
+	if got := tr.GetHttpSeedsArray(); len(got) != 1 || got[0] != httpSeeds[0] {
+		t.Errorf("GetHttpSeedsArray() = %v, want %v", got, httpSeeds)
+	}
+}
+
+func TestAttachMirrorsRejectsInvalidURL(t *testing.T) {
+	tr := &Torrent{}
+	if err := tr.AttachMirrors([]string{"not a url"}, nil); err == nil {
+		t.Error("expected an error for a non-http mirror url")
+	}
+	if got := tr.GetUrlListArray(); len(got) != 0 {
+		t.Errorf("GetUrlListArray() = %v, want nothing set after a rejected AttachMirrors", got)
+	}
+}
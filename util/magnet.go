@@ -0,0 +1,25 @@
+package util
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// InfoHashToMagnet : Takes an infohash, display name, BEP-19 web seed urls
+// and trackers and builds a magnet link out of them
+func InfoHashToMagnet(hash string, name string, urlList []string, trackers ...string) string {
+	magnet := fmt.Sprintf("magnet:?xt=urn:btih:%s&dn=%s", hash, url.QueryEscape(name))
+	for _, tracker := range trackers {
+		if tracker != "" {
+			magnet += "&tr=" + url.QueryEscape(tracker)
+		}
+	}
+	// BEP-53: web seeds are advertised in the magnet link as ws= params so
+	// clients can fall back to HTTP when the swarm has no seeders.
+	for _, ws := range urlList {
+		if ws != "" {
+			magnet += "&ws=" + url.QueryEscape(ws)
+		}
+	}
+	return magnet
+}
@@ -0,0 +1,48 @@
+package util
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestInfoHashToMagnet(t *testing.T) {
+	magnet := InfoHashToMagnet(
+		"0123456789abcdef0123456789abcdef01234567",
+		"release name",
+		[]string{"https://mirror.example/release/"},
+		"udp://tracker.example:80/announce",
+	)
+
+	u, err := url.Parse(magnet)
+	if err != nil {
+		t.Fatalf("parsing generated magnet: %v", err)
+	}
+	q := u.Query()
+
+	if got := q.Get("dn"); got != "release name" {
+		t.Errorf("dn = %q, want %q", got, "release name")
+	}
+	if got := q["tr"]; len(got) != 1 || got[0] != "udp://tracker.example:80/announce" {
+		t.Errorf("tr = %v, want [udp://tracker.example:80/announce]", got)
+	}
+	if got := q["ws"]; len(got) != 1 || got[0] != "https://mirror.example/release/" {
+		t.Errorf("ws = %v, want [https://mirror.example/release/]", got)
+	}
+}
+
+func TestInfoHashToMagnetSkipsEmptyValues(t *testing.T) {
+	magnet := InfoHashToMagnet("0123456789abcdef0123456789abcdef01234567", "name", []string{"", "https://mirror.example/"}, "", "udp://tracker.example:80/announce")
+
+	u, err := url.Parse(magnet)
+	if err != nil {
+		t.Fatalf("parsing generated magnet: %v", err)
+	}
+	q := u.Query()
+
+	if got := q["tr"]; len(got) != 1 {
+		t.Errorf("tr = %v, want exactly 1 non-empty tracker", got)
+	}
+	if got := q["ws"]; len(got) != 1 {
+		t.Errorf("ws = %v, want exactly 1 non-empty web seed", got)
+	}
+}